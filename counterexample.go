@@ -0,0 +1,253 @@
+// This file implements a structured parser for qmasm's textual sample
+// output, analogous to Verismith's CounterEg module.  It decodes each
+// sample's raw bit-vector variable assignments back into Prolog terms, so
+// that callers such as RunQMASMSamples, the fuzzer, and the reducer can
+// work with typed data instead of screen-scraping qmasm's output
+// themselves.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Term represents a decoded Prolog term: an atom, an integer, or a
+// compound term built from a functor applied to argument terms.
+type Term struct {
+	Functor string // Atom name, or the compound's functor
+	IsInt   bool   // True iff this term is an integer, in which case Functor is unused
+	Value   int    // Valid only when IsInt is true
+	Args    []Term // Empty for atoms and integers
+}
+
+// String renders t the way it would appear in Prolog source.
+func (t Term) String() string {
+	switch {
+	case t.IsInt:
+		return strconv.Itoa(t.Value)
+	case len(t.Args) == 0:
+		return t.Functor
+	default:
+		parts := make([]string, len(t.Args))
+		for i, a := range t.Args {
+			parts[i] = a.String()
+		}
+		return fmt.Sprintf("%s(%s)", t.Functor, strings.Join(parts, ", "))
+	}
+}
+
+// VarInfo describes how to decode a single Prolog variable's raw
+// bit-vector assignment from qmasm: either as a two's-complement integer,
+// or as an index into a symbol table built for that variable by
+// PerformTypeInference.
+type VarInfo struct {
+	IsInt   bool     // True iff the variable holds an integer
+	Symbols []string // Symbol table to index into when !IsInt
+}
+
+// VarTypeMap maps a Prolog variable's name, as it appears in qmasm
+// output, to the VarInfo PerformTypeInference computed for it.
+type VarTypeMap map[string]VarInfo
+
+// Sample represents one annealing solution reported by qmasm: its energy,
+// how many times it was observed, and the decoded value of each Prolog
+// variable appearing in the query.
+type Sample struct {
+	Energy      float64
+	Occurrences int
+	VarBindings map[string]Term
+}
+
+var sampleHeaderRE = regexp.MustCompile(`^Solution #\d+\s*\(energy\s*=\s*([-0-9.eE]+),\s*occurrences\s*=\s*(\d+)\)\s*$`)
+var bitVarRE = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*)\s*=\s*([01]+)\s*$`)
+
+// ParseQMASMOutput parses qmasm's textual sample output, decoding each
+// sample's raw bit-vector assignments into Prolog terms according to
+// vtys.
+func ParseQMASMOutput(r io.Reader, vtys VarTypeMap) ([]Sample, error) {
+	scanner := bufio.NewScanner(r)
+	var samples []Sample
+	var cur *Sample
+	bits := map[string]string{}
+	flush := func() {
+		if cur == nil {
+			return
+		}
+		cur.VarBindings = decodeBindings(bits, vtys)
+		samples = append(samples, *cur)
+		cur = nil
+		bits = map[string]string{}
+	}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := sampleHeaderRE.FindStringSubmatch(line); m != nil {
+			flush()
+			energy, err := strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("malformed energy %q: %w", m[1], err)
+			}
+			occurrences, err := strconv.Atoi(m[2])
+			if err != nil {
+				return nil, fmt.Errorf("malformed occurrence count %q: %w", m[2], err)
+			}
+			cur = &Sample{Energy: energy, Occurrences: occurrences}
+			continue
+		}
+		if m := bitVarRE.FindStringSubmatch(line); m != nil && cur != nil {
+			bits[m[1]] = m[2]
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// twosComplement interprets n, the value of a bits-wide bit string, as a
+// two's-complement signed integer: if the sign bit (bit bits-1) is set,
+// the result is n - 2^bits.
+func twosComplement(n uint64, bits int) int {
+	if bits <= 0 {
+		return int(n)
+	}
+	if bits >= 64 {
+		return int(int64(n))
+	}
+	if n&(uint64(1)<<uint(bits-1)) != 0 {
+		n -= uint64(1) << uint(bits)
+	}
+	return int(n)
+}
+
+// decodeBindings reassembles each variable's raw bit string into a Term
+// according to vtys.
+func decodeBindings(bits map[string]string, vtys VarTypeMap) map[string]Term {
+	out := make(map[string]Term, len(vtys))
+	for name, info := range vtys {
+		bitStr, ok := bits[name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(bitStr, 2, 64)
+		if err != nil {
+			continue
+		}
+		if info.IsInt {
+			out[name] = Term{IsInt: true, Value: twosComplement(n, len(bitStr))}
+			continue
+		}
+		if int(n) < 0 || int(n) >= len(info.Symbols) {
+			continue
+		}
+		out[name] = Term{Functor: info.Symbols[n]}
+	}
+	return out
+}
+
+// FilterByEnergy returns the subset of samples whose Energy is at most
+// maxEnergy.
+func FilterByEnergy(samples []Sample, maxEnergy float64) []Sample {
+	var out []Sample
+	for _, s := range samples {
+		if s.Energy <= maxEnergy {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// WriteSamplesText writes samples to w as text, one "Solution #N (energy =
+// E, occurrences = K)" header per sample, laid out like qmasm's own, followed
+// by one "name = value" line per variable in name order.  Values are the
+// decoded Prolog terms, not qmasm's raw bit strings, so this output is not
+// re-parsable by ParseQMASMOutput.
+func WriteSamplesText(w io.Writer, samples []Sample) error {
+	for i, s := range samples {
+		names := make([]string, 0, len(s.VarBindings))
+		for name := range s.VarBindings {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if _, err := fmt.Fprintf(w, "Solution #%d (energy = %g, occurrences = %d)\n", i+1, s.Energy, s.Occurrences); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "    %s = %s\n", name, s.VarBindings[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sampleJSON is the wire format written by WriteSamplesJSON: Term cannot
+// be marshaled directly since json doesn't know how to render it as
+// Prolog source, so bindings are rendered to strings first.
+type sampleJSON struct {
+	Energy      float64           `json:"energy"`
+	Occurrences int               `json:"occurrences"`
+	VarBindings map[string]string `json:"var_bindings"`
+}
+
+// WriteSamplesJSON writes samples to w as a JSON array, one object per
+// sample.
+func WriteSamplesJSON(w io.Writer, samples []Sample) error {
+	out := make([]sampleJSON, len(samples))
+	for i, s := range samples {
+		bindings := make(map[string]string, len(s.VarBindings))
+		for name, term := range s.VarBindings {
+			bindings[name] = term.String()
+		}
+		out[i] = sampleJSON{Energy: s.Energy, Occurrences: s.Occurrences, VarBindings: bindings}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// WriteSamplesCSV writes samples to w as CSV, with one variable per
+// column in addition to the energy and occurrences columns.
+func WriteSamplesCSV(w io.Writer, samples []Sample) error {
+	varNames := map[string]bool{}
+	for _, s := range samples {
+		for name := range s.VarBindings {
+			varNames[name] = true
+		}
+	}
+	header := []string{"energy", "occurrences"}
+	for name := range varNames {
+		header = append(header, name)
+	}
+	sort.Strings(header[2:])
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, s := range samples {
+		row := []string{
+			strconv.FormatFloat(s.Energy, 'g', -1, 64),
+			strconv.Itoa(s.Occurrences),
+		}
+		for _, name := range header[2:] {
+			if term, ok := s.VarBindings[name]; ok {
+				row = append(row, term.String())
+			} else {
+				row = append(row, "")
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
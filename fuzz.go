@@ -0,0 +1,415 @@
+// This file implements `qap fuzz`, a random Prolog program generator and
+// differential fuzzing harness.  Each generated program is run through the
+// normal compilation pipeline (WriteVerilog -> synthesis -> edif2qmasm ->
+// QMASM) and, independently, through SWI-Prolog; any disagreement between
+// the two answer sets is reported as a candidate compiler bug.
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config controls how `qap fuzz` generates random Prolog programs,
+// analogous to Verismith's procedural/randomMod Verilog generator.
+type Config struct {
+	MaxClauses int                // Maximum number of top-level clauses per program
+	MaxArity   int                // Maximum arity of a generated predicate
+	MaxDepth   int                // Maximum term/recursion depth
+	NodeProbs  map[string]float64 // Probability (0 to 1) of picking each kind of ASTNode where the generator chooses it; "rule" and "atom" are not read, since they are the fallbacks when "fact" or "integer"/"variable" are not picked
+	Seed       int64              // PRNG seed (0 picks one from the OS)
+}
+
+// DefaultFuzzConfig returns a Config with reasonable defaults for general-
+// purpose fuzzing.
+func DefaultFuzzConfig() *Config {
+	return &Config{
+		MaxClauses: 8,
+		MaxArity:   3,
+		MaxDepth:   4,
+		NodeProbs: map[string]float64{
+			"fact":     0.4,
+			"rule":     0.6,
+			"conj":     0.6,
+			"disj":     0.2,
+			"compound": 0.4,
+			"integer":  0.3,
+			"atom":     0.4,
+			"variable": 0.3,
+		},
+	}
+}
+
+// generator produces random, syntactically valid Prolog source text
+// according to a Config.
+type generator struct {
+	cfg     *Config
+	rng     *rand.Rand
+	atoms   []string
+	nextVar int
+}
+
+// newGenerator creates a generator seeded from cfg.
+func newGenerator(cfg *Config) *generator {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = int64(os.Getpid())
+	}
+	return &generator{
+		cfg:   cfg,
+		rng:   rand.New(rand.NewSource(seed)),
+		atoms: []string{"a", "b", "c", "foo", "bar", "baz"},
+	}
+}
+
+// chance returns true with probability p.
+func (g *generator) chance(p float64) bool {
+	return g.rng.Float64() < p
+}
+
+// freshVar returns a new, never-before-used Prolog variable name.
+func (g *generator) freshVar() string {
+	g.nextVar++
+	return fmt.Sprintf("X%d", g.nextVar)
+}
+
+// genTerm generates a random Prolog term of at most the given depth.
+func (g *generator) genTerm(depth int) string {
+	switch {
+	case depth <= 0 || !g.chance(g.cfg.NodeProbs["compound"]):
+		switch {
+		case g.chance(g.cfg.NodeProbs["integer"]):
+			return strconv.Itoa(g.rng.Intn(21) - 10)
+		case g.chance(g.cfg.NodeProbs["variable"]):
+			return g.freshVar()
+		default:
+			return g.atoms[g.rng.Intn(len(g.atoms))]
+		}
+	default:
+		arity := 1 + g.rng.Intn(g.cfg.MaxArity)
+		args := make([]string, arity)
+		for i := range args {
+			args[i] = g.genTerm(depth - 1)
+		}
+		return fmt.Sprintf("%s(%s)", g.atoms[g.rng.Intn(len(g.atoms))], strings.Join(args, ", "))
+	}
+}
+
+// genGoal generates a single goal (a call to a predicate of the given
+// name) for use in a clause body or query.
+func (g *generator) genGoal(name string, depth int) string {
+	arity := g.rng.Intn(g.cfg.MaxArity + 1)
+	if arity == 0 {
+		return name
+	}
+	args := make([]string, arity)
+	for i := range args {
+		args[i] = g.genTerm(depth)
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// genBody generates a conjunction (and, occasionally, a disjunction) of
+// goals for a clause body.
+func (g *generator) genBody(depth int) string {
+	goals := []string{g.genGoal(g.atoms[g.rng.Intn(len(g.atoms))], depth)}
+	for g.chance(g.cfg.NodeProbs["conj"]) && len(goals) < g.cfg.MaxArity {
+		goals = append(goals, g.genGoal(g.atoms[g.rng.Intn(len(g.atoms))], depth))
+	}
+	sep := ", "
+	if g.chance(g.cfg.NodeProbs["disj"]) {
+		sep = " ; "
+	}
+	return strings.Join(goals, sep)
+}
+
+// GenerateProgram generates a complete, syntactically valid Prolog
+// program, including a trailing query, as source text.
+func (g *generator) GenerateProgram() string {
+	var sb strings.Builder
+	for i := 0; i < g.cfg.MaxClauses; i++ {
+		head := g.genGoal(g.atoms[g.rng.Intn(len(g.atoms))], g.cfg.MaxDepth)
+		if g.chance(g.cfg.NodeProbs["fact"]) {
+			fmt.Fprintf(&sb, "%s.\n", head)
+		} else {
+			fmt.Fprintf(&sb, "%s :- %s.\n", head, g.genBody(g.cfg.MaxDepth))
+		}
+	}
+	fmt.Fprintf(&sb, "?- %s.\n", g.genGoal(g.atoms[g.rng.Intn(len(g.atoms))], g.cfg.MaxDepth))
+	return sb.String()
+}
+
+// splitTopLevel splits s on commas that appear at parenthesis/bracket
+// depth 0, so that a compound term's own argument list (e.g.
+// "bar(X, Y), baz(Z)") isn't shattered into broken fragments the way a
+// bare strings.Split(s, ",") would.
+func splitTopLevel(s string) []string {
+	var parts []string
+	depth := 0
+	start := 0
+	for i, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[start:])
+	return parts
+}
+
+// extractQuery pulls the goal and its free variables (Prolog variables
+// start with an upper-case letter or underscore), in order of first
+// appearance, out of the trailing "?- Goal." line of a generated or
+// reduced program.
+func extractQuery(src string) (goal string, vars []string, ok bool) {
+	lines := strings.Split(strings.TrimSpace(src), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if !strings.HasPrefix(line, "?-") {
+			continue
+		}
+		goal = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "?-"), "."))
+		varRE := regexp.MustCompile(`\b[A-Z_][A-Za-z0-9_]*\b`)
+		seen := map[string]bool{}
+		for _, v := range varRE.FindAllString(goal, -1) {
+			if v == "_" || seen[v] {
+				continue
+			}
+			seen[v] = true
+			vars = append(vars, v)
+		}
+		return goal, vars, true
+	}
+	return "", nil, false
+}
+
+// swiplTimeout bounds how long a single generated program's query may run
+// under SWI-Prolog. genGoal/genBody draw goal and clause names from a
+// small, fixed atom pool, so self- and mutually-recursive clauses such as
+// "foo(X) :- foo(X)." are a routine generated output; SWI-Prolog applies
+// last-call optimization to such a loop, so it runs forever without ever
+// growing the stack far enough to trip a resource limit. Without a
+// timeout, the first such program hangs `qap fuzz` forever.
+const swiplTimeout = 5 * time.Second
+
+// swiplAnswerSet runs src's query under SWI-Prolog via findall/3 and
+// returns the set of distinct answers it finds, one canonical
+// comma-joined tuple per solution (in the same order as vars), so that it
+// can be compared against qmasmAnswerSet's decoding of QMASM's samples. A
+// query that runs longer than swiplTimeout (e.g. an infinite recursion)
+// is treated the same as any other failure: the caller skips the program.
+func swiplAnswerSet(src string) (answers map[string]bool, vars []string, err error) {
+	goal, vars, ok := extractQuery(src)
+	if !ok || len(vars) == 0 {
+		return nil, vars, fmt.Errorf("no query with free variables to compare")
+	}
+	program := src[:strings.LastIndex(src, "?-")]
+	harness := fmt.Sprintf("%s\nmain :- ( findall([%s], (%s), Sols) -> forall(member(S, Sols), (format(\"ANSWER ~w~n\", [S]))) ; true ).\n:- initialization(main).\n",
+		program, strings.Join(vars, ", "), goal)
+
+	f, ferr := os.CreateTemp("", "qap-fuzz-*.pl")
+	if ferr != nil {
+		return nil, vars, ferr
+	}
+	defer os.Remove(f.Name())
+	if _, werr := f.WriteString(harness); werr != nil {
+		f.Close()
+		return nil, vars, werr
+	}
+	f.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), swiplTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, "swipl", "-q", "-t", "halt", f.Name())
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if runErr := cmd.Run(); runErr != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, vars, fmt.Errorf("swipl timed out after %s (likely an infinite loop): %w", swiplTimeout, ctx.Err())
+		}
+		return nil, vars, runErr
+	}
+
+	answers = map[string]bool{}
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "ANSWER [") || !strings.HasSuffix(line, "]") {
+			continue
+		}
+		tuple := strings.TrimSuffix(strings.TrimPrefix(line, "ANSWER ["), "]")
+		parts := splitTopLevel(tuple)
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		answers[strings.Join(parts, ",")] = true
+	}
+	return answers, vars, nil
+}
+
+// qmasmAnswerSet extracts the set of distinct answers QMASM reported for
+// vars, in the same canonical comma-joined-tuple form as swiplAnswerSet.
+func qmasmAnswerSet(samples []Sample, vars []string) map[string]bool {
+	answers := make(map[string]bool, len(samples))
+	for _, s := range samples {
+		parts := make([]string, len(vars))
+		for i, v := range vars {
+			if t, ok := s.VarBindings[v]; ok {
+				parts[i] = t.String()
+			} else {
+				parts[i] = "?"
+			}
+		}
+		answers[strings.Join(parts, ",")] = true
+	}
+	return answers
+}
+
+// answerSetsAgree reports whether two answer sets, as produced by
+// swiplAnswerSet and qmasmAnswerSet, contain exactly the same answers.
+func answerSetsAgree(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// PipelineError distinguishes a failure in the synthesis/annealing
+// pipeline itself (e.g. yosys or edif2qmasm exiting non-zero on a
+// candidate program) from a failure to parse or otherwise make sense of
+// src. Callers such as qap reduce's "yosys-fail" mode need to tell "the
+// tool under test failed" (interesting) apart from "our own mutation
+// produced a syntactically broken candidate" (not interesting).
+type PipelineError struct {
+	Stage string
+	Err   error
+}
+
+func (e *PipelineError) Error() string { return fmt.Sprintf("%s: %v", e.Stage, e.Err) }
+func (e *PipelineError) Unwrap() error { return e.Err }
+
+// runPipelineStep runs name with args in the current directory, wrapping
+// a non-zero exit as a *PipelineError. Unlike RunCommand, this never
+// aborts the process: for the fuzzer and reducer, the tool under test
+// failing is a routine, expected outcome, not a fatal one.
+func runPipelineStep(stage, name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return &PipelineError{Stage: stage, Err: fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))}
+	}
+	return nil
+}
+
+// compileAndRunQMASM runs src through the normal compilation pipeline
+// (WriteVerilog -> synthesis -> edif2qmasm -> QMASM) using p.Synth as the
+// synthesis backend, and returns the samples QMASM reported, decoded via
+// ParseQMASMOutput. Synthesis and edif2qmasm both run through
+// SynthTool.RunNonFatal/runPipelineStep rather than RunCommand, so that a
+// candidate program the backend rejects comes back as a *PipelineError
+// instead of aborting the whole run.
+func compileAndRunQMASM(p *Parameters, src string) (samples []Sample, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while compiling: %v", r)
+		}
+	}()
+	a, perr := ParseReader(p.InFileName, strings.NewReader(src))
+	if perr != nil {
+		return nil, perr
+	}
+	ast := a.(*ASTNode)
+	queryNodes := ast.FindByType(QueryType)
+	if len(queryNodes) == 0 {
+		return nil, fmt.Errorf("generated program has no query")
+	}
+	ast.RejectUnimplemented(p)
+	ast.StoreAtomNames(p)
+	ast.AdjustIntBits(p)
+	ast.BinClauses(p)
+	nm2tys, clVarTys := ast.PerformTypeInference()
+	vtys := clVarTys[queryNodes[0]]
+
+	p.OutFileBase = "fuzz"
+	vf, cerr := os.Create(p.OutFileBase + ".v")
+	if cerr != nil {
+		return nil, cerr
+	}
+	ast.WriteVerilog(vf, p, nm2tys, clVarTys)
+	vf.Close()
+
+	synth, serr := SynthToolByName(p.Synth)
+	if serr != nil {
+		return nil, serr
+	}
+	if rerr := synth.RunNonFatal(p); rerr != nil {
+		return nil, rerr
+	}
+	if eerr := runPipelineStep("edif2qmasm", "edif2qmasm", "-o", p.OutFileBase+".qmasm", p.OutFileBase+".edif"); eerr != nil {
+		return nil, eerr
+	}
+
+	return RunQMASMSamples(p, vtys)
+}
+
+// runFuzz implements the `qap fuzz` subcommand.
+func runFuzz(args []string) {
+	notify = log.New(os.Stderr, "qap fuzz: ", 0)
+	cfg := DefaultFuzzConfig()
+	fs := flag.NewFlagSet("qap fuzz", flag.ExitOnError)
+	numPrograms := fs.Int("n", 100, "number of random programs to generate")
+	fs.IntVar(&cfg.MaxClauses, "max-clauses", cfg.MaxClauses, "maximum number of clauses per generated program")
+	fs.IntVar(&cfg.MaxArity, "max-arity", cfg.MaxArity, "maximum arity of a generated predicate")
+	fs.IntVar(&cfg.MaxDepth, "max-depth", cfg.MaxDepth, "maximum term depth of a generated predicate")
+	fs.Int64Var(&cfg.Seed, "seed", 0, "PRNG seed (0 picks one arbitrarily)")
+	synthName := fs.String("synth", "yosys", "synthesis backend to use to lower Verilog to EDIF")
+	fs.Parse(args)
+
+	p := &Parameters{ProgName: "qap fuzz", Synth: *synthName}
+	CreateWorkDir(p)
+	defer os.RemoveAll(p.WorkDir)
+	err := os.Chdir(p.WorkDir)
+	CheckError(err)
+
+	g := newGenerator(cfg)
+	nBugs := 0
+	for i := 0; i < *numPrograms; i++ {
+		src := g.GenerateProgram()
+		swiplAnswers, vars, swiplErr := swiplAnswerSet(src)
+		samples, qmasmErr := compileAndRunQMASM(p, src)
+		if qmasmErr != nil {
+			continue // Not every random program compiles; that's expected.
+		}
+		if swiplErr != nil {
+			continue // No comparable query (e.g. no free variables); nothing to diff.
+		}
+		qmasmAnswers := qmasmAnswerSet(samples, vars)
+		if !answerSetsAgree(swiplAnswers, qmasmAnswers) {
+			nBugs++
+			fmt.Printf("=== Divergence found (program %d) ===\n%s\n--- SWI-Prolog answers ---\n%v\n--- QMASM answers ---\n%v\n",
+				i, src, swiplAnswers, qmasmAnswers)
+		}
+	}
+	fmt.Printf("Generated %d program(s); found %d divergence(s)\n", *numPrograms, nBugs)
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path"
 	"strings"
@@ -44,6 +45,10 @@ type Parameters struct {
 	Verbose    bool     // Whether to output verbose execution information
 	Query      string   // Query to apply to the program
 	QmasmArgs  []string // Additional qmasm command-line arguments
+	Synth      string   // Name of the synthesis backend to use (yosys, vivado, quartus)
+	Format     string   // Output format for QMASM results: text, json, or csv
+	MaxEnergy  float64  // Discard samples whose energy exceeds this value
+	Simulate   string   // Name of the classical simulator to pre-flight with, or "" to skip
 
 	// Computed values
 	SymToInt      map[string]int        // Map from a symbol to an integer
@@ -65,26 +70,51 @@ func VerbosePrintf(p *Parameters, fmt string, args ...interface{}) {
 	notify.Printf("INFO: "+fmt, args...)
 }
 
+// main dispatches to the requested qap subcommand.  With no subcommand (or
+// an input file in its place), it falls back to the original compile-and-run
+// behavior for backward compatibility.
 func main() {
+	args := os.Args[1:]
+	if len(args) > 0 {
+		switch args[0] {
+		case "fuzz":
+			runFuzz(args[1:])
+			return
+		case "reduce":
+			runReduce(args[1:])
+			return
+		}
+	}
+	runCompile(args)
+}
+
+// runCompile implements qap's default mode: compile a single Prolog program
+// down to QMASM code and run it on a quantum annealer.
+func runCompile(args []string) {
 	// Parse the command line.
 	p := Parameters{}
 	p.ProgName = BaseName(os.Args[0])
 	notify = log.New(os.Stderr, p.ProgName+": ", 0)
-	flag.Usage = func() {
+	fs := flag.NewFlagSet(p.ProgName, flag.ExitOnError)
+	fs.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [<options>] [<infile.pl>]\n\n", p.ProgName)
-		flag.PrintDefaults()
+		fs.PrintDefaults()
 	}
-	flag.StringVar(&p.Query, "query", "", "Prolog query to apply to the program")
-	flag.UintVar(&p.IntBits, "int-bits", 0, "minimum integer width in bits")
-	flag.StringVar(&p.WorkDir, "work-dir", "", "directory for storing intermediate files (default: "+path.Join(os.TempDir(), "qap-*")+")")
-	flag.BoolVar(&p.Verbose, "verbose", false, "output informational messages during execution")
-	flag.BoolVar(&p.Verbose, "v", false, "same as -verbose")
-	qmasmStr := flag.String("qmasm-args", "", "additional command-line arguments to pass to qmasm")
-	flag.Parse()
-	if flag.NArg() == 0 {
+	fs.StringVar(&p.Query, "query", "", "Prolog query to apply to the program")
+	fs.UintVar(&p.IntBits, "int-bits", 0, "minimum integer width in bits")
+	fs.StringVar(&p.WorkDir, "work-dir", "", "directory for storing intermediate files (default: "+path.Join(os.TempDir(), "qap-*")+")")
+	fs.BoolVar(&p.Verbose, "verbose", false, "output informational messages during execution")
+	fs.BoolVar(&p.Verbose, "v", false, "same as -verbose")
+	fs.StringVar(&p.Synth, "synth", "yosys", "synthesis backend to use to lower Verilog to EDIF (yosys, vivado, quartus)")
+	fs.StringVar(&p.Format, "format", "text", "output format for QMASM results: text, json, or csv")
+	fs.Float64Var(&p.MaxEnergy, "max-energy", math.Inf(1), "discard samples whose energy exceeds this value")
+	fs.StringVar(&p.Simulate, "simulate", "", "classical Verilog simulator to sanity-check the circuit with before annealing (e.g. icarus)")
+	qmasmStr := fs.String("qmasm-args", "", "additional command-line arguments to pass to qmasm")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
 		p.InFileName = "<stdin>"
 	} else {
-		p.InFileName = flag.Arg(0)
+		p.InFileName = fs.Arg(0)
 	}
 	p.QmasmArgs = strings.Fields(*qmasmStr)
 	ParseError = func(pos position, format string, args ...interface{}) {
@@ -96,7 +126,7 @@ func main() {
 
 	// Open the input file.
 	var r io.Reader = os.Stdin
-	if flag.NArg() > 0 {
+	if fs.NArg() > 0 {
 		f, err := os.Open(p.InFileName)
 		CheckError(err)
 		defer f.Close()
@@ -145,18 +175,45 @@ func main() {
 	ast.WriteVerilog(vf, &p, nm2tys, clVarTys)
 	vf.Close()
 
-	// Compile the Verilog code to an EDIF netlist.
-	CreateYosysScript(&p)
-	VerbosePrintf(&p, "Converting Verilog code to an EDIF netlist")
-	RunCommand(&p, "yosys", "-q", "-s", p.OutFileBase+".ys",
-		"-b", "edif", "-o", p.OutFileBase+".edif", p.OutFileBase+".v")
+	// Optionally run the generated Verilog through a classical simulator
+	// as a fast, deterministic pre-flight check before annealing.
+	if p.Simulate != "" {
+		sim, err := SimulatorByName(p.Simulate)
+		CheckError(err)
+		vtys := clVarTys[ast.FindByType(QueryType)[0]]
+		VerbosePrintf(&p, "Simulating %s with %s as a pre-annealing sanity check", vName, sim.Name())
+		ok, err := sim.Simulate(&p, vtys)
+		CheckError(err)
+		if !ok {
+			notify.Fatal("simulation found no assignment of the free query variables for which the circuit is valid")
+		}
+	}
+
+	// Compile the Verilog code to an EDIF netlist using the selected
+	// synthesis backend.
+	synth, err := SynthToolByName(p.Synth)
+	CheckError(err)
+	VerbosePrintf(&p, "Converting Verilog code to an EDIF netlist using %s", synth.Name())
+	CheckError(synth.Run(&p))
 
 	// Compile the EDIF netlist to QMASM code.
 	VerbosePrintf(&p, "Converting the EDIF netlist to QMASM code")
 	RunCommand(&p, "edif2qmasm", "-o", p.OutFileBase+".qmasm", p.OutFileBase+".edif")
 
-	// Run the QMASM code and report the results.
-	ast.RunQMASM(&p, clVarTys)
+	// Run the QMASM code, decode its samples, and report the results in
+	// the requested format.
+	vtys := clVarTys[ast.FindByType(QueryType)[0]]
+	samples, err := RunQMASMSamples(&p, vtys)
+	CheckError(err)
+	samples = FilterByEnergy(samples, p.MaxEnergy)
+	switch p.Format {
+	case "json":
+		CheckError(WriteSamplesJSON(os.Stdout, samples))
+	case "csv":
+		CheckError(WriteSamplesCSV(os.Stdout, samples))
+	default:
+		CheckError(WriteSamplesText(os.Stdout, samples))
+	}
 
 	// Optionally remove the working directory.
 	if p.DeleteWorkDir {
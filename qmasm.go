@@ -0,0 +1,28 @@
+// This file runs qmasm directly and decodes its output via
+// ParseQMASMOutput. Earlier revisions captured the legacy RunQMASM's
+// stdout through a redirected pipe and regex-scraped it back into
+// Samples, which just pushed RunQMASM's ad-hoc text reporting up one
+// layer; calling qmasm ourselves and parsing its one true stdout stream
+// avoids that redirection entirely.
+package main
+
+import (
+	"bytes"
+	"os/exec"
+)
+
+// RunQMASMSamples runs qmasm against p.OutFileBase+".qmasm", passing
+// along p.QmasmArgs, and decodes its reported solutions into Samples
+// typed according to vtys.
+func RunQMASMSamples(p *Parameters, vtys VarTypeMap) ([]Sample, error) {
+	args := append(append([]string{}, p.QmasmArgs...), p.OutFileBase+".qmasm")
+	out, err := exec.Command("qmasm", args...).Output()
+	if err != nil {
+		return nil, &PipelineError{Stage: "qmasm", Err: err}
+	}
+	samples, perr := ParseQMASMOutput(bytes.NewReader(out), vtys)
+	if perr != nil {
+		return nil, &PipelineError{Stage: "qmasm", Err: perr}
+	}
+	return samples, nil
+}
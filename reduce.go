@@ -0,0 +1,350 @@
+// This file implements `qap reduce`, a delta-debugging test-case reducer
+// for Prolog source files that trigger a bug somewhere in the compilation
+// pipeline, mirroring Verismith's runReduce shrinker.  Given a source file
+// and an "interestingness" predicate, it repeatedly deletes or simplifies
+// pieces of the program and keeps each mutation only if the predicate
+// still holds, converging on a minimal failing example.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Interesting reports whether src still exhibits the bug being minimized.
+type Interesting func(src string) bool
+
+// builtinInteresting returns one of a few canned Interesting predicates,
+// run against a fresh compilation of src.
+func builtinInteresting(mode string, p *Parameters) (Interesting, error) {
+	switch mode {
+	case "yosys-fail":
+		// Only a genuine pipeline failure (yosys, edif2qmasm, or qmasm
+		// itself rejecting the candidate) counts as interesting here. A
+		// plain error instead means ddmin mangled src into something that
+		// no longer parses or has no query, which isn't the bug being
+		// reduced and must not be kept.
+		return func(src string) bool {
+			_, err := compileAndRunQMASM(p, src)
+			var pe *PipelineError
+			return errors.As(err, &pe)
+		}, nil
+	case "no-answer":
+		return func(src string) bool {
+			samples, err := compileAndRunQMASM(p, src)
+			return err == nil && len(samples) == 0
+		}, nil
+	case "disagree-swipl":
+		return func(src string) bool {
+			samples, qerr := compileAndRunQMASM(p, src)
+			if qerr != nil {
+				return false
+			}
+			swiplAnswers, vars, serr := swiplAnswerSet(src)
+			if serr != nil {
+				return false
+			}
+			return !answerSetsAgree(swiplAnswers, qmasmAnswerSet(samples, vars))
+		}, nil
+	}
+	return nil, fmt.Errorf("unrecognized built-in interestingness mode %q", mode)
+}
+
+// shellInteresting returns an Interesting predicate that writes src to a
+// temporary file and reports the program interesting iff cmdTemplate,
+// invoked with the temporary file's path as its final argument, exits
+// with status 0.
+func shellInteresting(cmdTemplate string) Interesting {
+	fields := strings.Fields(cmdTemplate)
+	return func(src string) bool {
+		f, err := os.CreateTemp("", "qap-reduce-*.pl")
+		if err != nil {
+			return false
+		}
+		defer os.Remove(f.Name())
+		if _, err := f.WriteString(src); err != nil {
+			f.Close()
+			return false
+		}
+		f.Close()
+		args := append(append([]string{}, fields[1:]...), f.Name())
+		cmd := exec.Command(fields[0], args...)
+		return cmd.Run() == nil
+	}
+}
+
+// splitClauses splits src into its top-level clauses. A clause ends at a
+// "." that is followed by whitespace or end-of-input and that falls
+// outside any parenthesis, bracket, or quoted atom/string, so a clause
+// spanning several lines (or a compound term containing its own periods,
+// e.g. inside a quoted atom) is kept whole instead of being torn apart at
+// every newline the way a one-clause-per-line split would.
+func splitClauses(src string) []string {
+	var clauses []string
+	runes := []rune(src)
+	depth := 0
+	start := 0
+	var quote rune
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case quote != 0:
+			if r == '\\' && i+1 < len(runes) {
+				i++
+			} else if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '(' || r == '[':
+			depth++
+		case r == ')' || r == ']':
+			depth--
+		case r == '.' && depth == 0 && (i+1 == len(runes) || unicode.IsSpace(runes[i+1])):
+			if clause := strings.TrimSpace(string(runes[start : i+1])); clause != "" {
+				clauses = append(clauses, clause)
+			}
+			start = i + 1
+		}
+	}
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		clauses = append(clauses, rest)
+	}
+	return clauses
+}
+
+// ddminClauses repeatedly removes whole clauses from clauses, keeping a
+// removal iff the result is still interesting, until no single clause can
+// be removed.
+func ddminClauses(clauses []string, interesting Interesting) []string {
+	for i := 0; i < len(clauses); {
+		candidate := append(append([]string{}, clauses[:i]...), clauses[i+1:]...)
+		if interesting(strings.Join(candidate, "\n") + "\n") {
+			clauses = candidate
+			continue
+		}
+		i++
+	}
+	return clauses
+}
+
+// ddminGoals removes individual goals from rule bodies (clauses containing
+// ":-"), keeping a removal iff the clause list is still interesting.
+func ddminGoals(clauses []string, interesting Interesting) []string {
+	for ci, clause := range clauses {
+		parts := strings.SplitN(clause, ":-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		head, body := parts[0], strings.TrimSuffix(strings.TrimSpace(parts[1]), ".")
+		goals := splitTopLevel(body)
+		for gi := 0; gi < len(goals); {
+			if len(goals) == 1 {
+				break // Keep at least one goal; dropping the last one changes this to a fact.
+			}
+			candidateGoals := append(append([]string{}, goals[:gi]...), goals[gi+1:]...)
+			candidateClause := fmt.Sprintf("%s:- %s.", head, strings.Join(candidateGoals, ","))
+			candidate := append(append([]string{}, clauses[:ci]...), candidateClause)
+			candidate = append(candidate, clauses[ci+1:]...)
+			if interesting(strings.Join(candidate, "\n") + "\n") {
+				goals = candidateGoals
+				clauses = candidate
+				continue
+			}
+			gi++
+		}
+	}
+	return clauses
+}
+
+// shrinkIntegers replaces each integer literal in src with half its value
+// (rounded toward zero), keeping the change iff src is still interesting,
+// until no literal can be halved without losing interestingness.  Other
+// smaller values are not tried.
+func shrinkIntegers(src string, interesting Interesting) string {
+	intRE := regexp.MustCompile(`-?\b\d+\b`)
+	for {
+		changed := false
+		locs := intRE.FindAllStringIndex(src, -1)
+		for _, loc := range locs {
+			lit := src[loc[0]:loc[1]]
+			n, err := strconv.Atoi(lit)
+			if err != nil || n == 0 {
+				continue
+			}
+			smaller := n / 2
+			candidate := src[:loc[0]] + strconv.Itoa(smaller) + src[loc[1]:]
+			if interesting(candidate) {
+				src = candidate
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			return src
+		}
+	}
+}
+
+// shrinkAtoms tries renaming every distinct lowercase atom in src to a
+// single canonical atom ("a"), keeping the rename iff src is still
+// interesting.
+func shrinkAtoms(src string, interesting Interesting) string {
+	atomRE := regexp.MustCompile(`\b[a-z][a-zA-Z0-9_]*\b`)
+	seen := map[string]bool{}
+	for _, atom := range atomRE.FindAllString(src, -1) {
+		if atom == "a" || seen[atom] {
+			continue
+		}
+		seen[atom] = true
+		candidate := regexp.MustCompile(`\b`+regexp.QuoteMeta(atom)+`\b`).ReplaceAllString(src, "a")
+		if interesting(candidate) {
+			src = candidate
+		}
+	}
+	return src
+}
+
+// compoundSpan locates a single compound term (functor(arg1, ..., argN))
+// within a larger source string.
+type compoundSpan struct {
+	start, end int
+	args       []string
+}
+
+var compoundHeadRE = regexp.MustCompile(`[a-z][A-Za-z0-9_]*\(`)
+
+// topLevelCompounds finds every compound term in src, returning each
+// one's span in src together with its top-level argument strings, so
+// that shrinkCompounds can try collapsing the whole term down to one of
+// its own arguments.
+func topLevelCompounds(src string) []compoundSpan {
+	var spans []compoundSpan
+	for _, loc := range compoundHeadRE.FindAllStringIndex(src, -1) {
+		open := loc[1] - 1
+		depth := 0
+		end := -1
+		for i := open; i < len(src); i++ {
+			switch src[i] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					end = i + 1
+				}
+			}
+			if end >= 0 {
+				break
+			}
+		}
+		if end < 0 {
+			continue
+		}
+		args := splitTopLevel(src[open+1 : end-1])
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		spans = append(spans, compoundSpan{start: loc[0], end: end, args: args})
+	}
+	return spans
+}
+
+// shrinkCompounds tries replacing each compound term occurring in src
+// with one of its own arguments, or with a fresh variable, keeping the
+// replacement iff src is still interesting. This reaches structure that
+// clause/goal removal and atom/integer renaming can't: it lets, e.g.,
+// foo(bar(X), Y) collapse to bar(X) and, on a later pass, to X, provided
+// each step is still interesting.
+func shrinkCompounds(src string, interesting Interesting) string {
+	freshNum := 0
+	for {
+		changed := false
+		for _, sp := range topLevelCompounds(src) {
+			candidates := append(append([]string{}, sp.args...), fmt.Sprintf("_G%d", freshNum))
+			for _, repl := range candidates {
+				candidate := src[:sp.start] + repl + src[sp.end:]
+				if interesting(candidate) {
+					src = candidate
+					changed = true
+					break
+				}
+			}
+			if changed {
+				break
+			}
+		}
+		if !changed {
+			return src
+		}
+		freshNum++
+	}
+}
+
+// Reduce minimizes src, a Prolog program, with respect to interesting,
+// iterating clause removal, goal removal, compound-term collapsing, and
+// literal shrinking to a fixpoint.
+func Reduce(src string, interesting Interesting) string {
+	if !interesting(src) {
+		notify.Fatal("the input program is not interesting to begin with")
+	}
+	for {
+		before := src
+		clauses := splitClauses(src)
+		clauses = ddminClauses(clauses, interesting)
+		clauses = ddminGoals(clauses, interesting)
+		src = strings.Join(clauses, "\n") + "\n"
+		src = shrinkCompounds(src, interesting)
+		src = shrinkIntegers(src, interesting)
+		src = shrinkAtoms(src, interesting)
+		if src == before {
+			return src
+		}
+	}
+}
+
+// runReduce implements the `qap reduce` subcommand.
+func runReduce(args []string) {
+	notify = log.New(os.Stderr, "qap reduce: ", 0)
+	fs := flag.NewFlagSet("qap reduce", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: qap reduce [<options>] <infile.pl>\n\n")
+		fs.PrintDefaults()
+	}
+	mode := fs.String("interesting", "yosys-fail", "built-in interestingness predicate: yosys-fail, no-answer, or disagree-swipl")
+	cmdline := fs.String("cmd", "", "shell command to use as the interestingness predicate instead of -interesting")
+	synthName := fs.String("synth", "yosys", "synthesis backend to use to lower Verilog to EDIF")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	src, err := os.ReadFile(fs.Arg(0))
+	CheckError(err)
+
+	p := &Parameters{ProgName: "qap reduce", InFileName: fs.Arg(0), Synth: *synthName}
+	CreateWorkDir(p)
+	defer os.RemoveAll(p.WorkDir)
+	err = os.Chdir(p.WorkDir)
+	CheckError(err)
+
+	var interesting Interesting
+	if *cmdline != "" {
+		interesting = shellInteresting(*cmdline)
+	} else {
+		interesting, err = builtinInteresting(*mode, p)
+		CheckError(err)
+	}
+
+	reduced := Reduce(string(src), interesting)
+	fmt.Print(reduced)
+}
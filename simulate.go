@@ -0,0 +1,151 @@
+// This file implements -simulate, an optional pre-annealing sanity check.
+// Quantum annealing runs are expensive, so before shelling out through
+// edif2qmasm and qmasm, -simulate drives the compiled Verilog module
+// with a classical simulator and confirms that at least one assignment of
+// the free query variables makes the top-level module's "valid" signal
+// assert.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// Simulator abstracts over a classical Verilog simulator.
+type Simulator interface {
+	// Name returns the simulator's name, as given to -simulate.
+	Name() string
+
+	// Simulate drives p.OutFileBase+".v" with a testbench that tries
+	// every assignment of the free variables described by vtys, and
+	// reports whether any assignment makes the module's "valid" output
+	// signal assert.
+	Simulate(p *Parameters, vtys VarTypeMap) (bool, error)
+}
+
+// simulators maps each -simulate flag value to its Simulator implementation.
+var simulators = map[string]Simulator{
+	"icarus": IcarusSimulator{},
+}
+
+// SimulatorByName looks up a Simulator by the name given to -simulate.
+func SimulatorByName(name string) (Simulator, error) {
+	s, ok := simulators[name]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized simulator %q", name)
+	}
+	return s, nil
+}
+
+// IcarusSimulator drives a compiled module with Icarus Verilog
+// (iverilog/vvp).
+type IcarusSimulator struct{}
+
+// Name returns "icarus".
+func (IcarusSimulator) Name() string { return "icarus" }
+
+// Simulate compiles p.OutFileBase+".v" together with a generated
+// testbench and runs it under vvp.
+func (IcarusSimulator) Simulate(p *Parameters, vtys VarTypeMap) (bool, error) {
+	tb, err := genTestbench(p, vtys)
+	if err != nil {
+		return false, err
+	}
+	tbName := p.OutFileBase + "_tb.v"
+	if err := os.WriteFile(tbName, []byte(tb), 0644); err != nil {
+		return false, err
+	}
+	simName := p.OutFileBase + "_sim"
+	RunCommand(p, "iverilog", "-o", simName, tbName, p.OutFileBase+".v")
+	out, err := exec.Command("vvp", simName).CombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return strings.Contains(string(out), "QAP_VALID_FOUND"), nil
+}
+
+// varWidth returns the number of bits a variable described by info
+// occupies, using p's globally computed bit widths.
+func varWidth(p *Parameters, info VarInfo) uint {
+	if info.IsInt {
+		return p.IntBits
+	}
+	return p.SymBits
+}
+
+// maxExhaustiveBits caps how many free-variable bits genTestbench will
+// exhaustively enumerate. 2^24 is already 16 million simulation steps;
+// beyond that, brute force stops being a sound search strategy, and
+// Verilog's 32-bit "integer" loop counter (and Go's own 1<<uint(bits), for
+// bits >= 64) stop being able to even represent the combination count.
+const maxExhaustiveBits = 24
+
+// genTestbench generates a self-checking testbench that exhaustively
+// drives every combination of the free variables described by vtys into
+// the top-level module p.OutFileBase, printing QAP_VALID_FOUND the moment
+// any combination makes the module's "valid" output assert. It refuses to
+// generate a testbench requiring more than 2^maxExhaustiveBits
+// combinations, reporting an error instead of silently truncating or
+// wrapping around to a wrong answer.
+func genTestbench(p *Parameters, vtys VarTypeMap) (string, error) {
+	var names []string
+	for name := range vtys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	totalBits := 0
+	for _, name := range names {
+		totalBits += int(varWidth(p, vtys[name]))
+	}
+	if totalBits > maxExhaustiveBits {
+		return "", fmt.Errorf("query has %d free bit(s) of variables, too many to exhaustively simulate (limit is %d); narrow the query or lower -int-bits", totalBits, maxExhaustiveBits)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "// Auto-generated by qap -simulate; do not edit.\n")
+	fmt.Fprintf(&sb, "module %s_tb;\n", p.OutFileBase)
+	if totalBits > 0 {
+		fmt.Fprintf(&sb, "\treg [%d:0] combo;\n", totalBits-1)
+	}
+	fmt.Fprintf(&sb, "\twire valid;\n")
+	ports := make([]string, 0, len(names))
+	offset := 0
+	for _, name := range names {
+		w := int(varWidth(p, vtys[name]))
+		fmt.Fprintf(&sb, "\twire [%d:0] %s = combo[%d:%d];\n", w-1, name, offset+w-1, offset)
+		ports = append(ports, fmt.Sprintf(".%s(%s)", name, name))
+		offset += w
+	}
+	ports = append(ports, ".valid(valid)")
+	fmt.Fprintf(&sb, "\t%s dut(%s);\n", p.OutFileBase, strings.Join(ports, ", "))
+	if totalBits > 0 {
+		fmt.Fprintf(&sb, "\tinteger i;\n")
+	}
+	fmt.Fprintf(&sb, "\tinitial begin\n")
+	if totalBits == 0 {
+		// No free variables: there is exactly one combination to check.
+		fmt.Fprintf(&sb, "\t\t#1;\n")
+		fmt.Fprintf(&sb, "\t\tif (valid) begin\n")
+		fmt.Fprintf(&sb, "\t\t\t$display(\"QAP_VALID_FOUND\");\n")
+		fmt.Fprintf(&sb, "\t\t\t$finish;\n")
+		fmt.Fprintf(&sb, "\t\tend\n")
+	} else {
+		fmt.Fprintf(&sb, "\t\tfor (i = 0; i < %d; i = i + 1) begin\n", 1<<uint(totalBits))
+		fmt.Fprintf(&sb, "\t\t\tcombo = i;\n")
+		fmt.Fprintf(&sb, "\t\t\t#1;\n")
+		fmt.Fprintf(&sb, "\t\t\tif (valid) begin\n")
+		fmt.Fprintf(&sb, "\t\t\t\t$display(\"QAP_VALID_FOUND\");\n")
+		fmt.Fprintf(&sb, "\t\t\t\t$finish;\n")
+		fmt.Fprintf(&sb, "\t\t\tend\n")
+		fmt.Fprintf(&sb, "\t\tend\n")
+	}
+	fmt.Fprintf(&sb, "\t\t$display(\"QAP_VALID_NOT_FOUND\");\n")
+	fmt.Fprintf(&sb, "\tend\n")
+	fmt.Fprintf(&sb, "endmodule\n")
+	return sb.String(), nil
+}
@@ -0,0 +1,161 @@
+// This file defines the SynthTool abstraction used to lower the Verilog
+// code emitted by WriteVerilog down to an EDIF netlist that edif2qmasm can
+// consume.  Different synthesis toolchains produce different netlist
+// structures, and therefore different Hamiltonian encodings, so being able
+// to swap backends lets users compare qubit counts and chain lengths or
+// work around bugs in any single synthesizer.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// SynthTool abstracts over a synthesis toolchain capable of lowering
+// p.OutFileBase+".v" to p.OutFileBase+".edif".
+type SynthTool interface {
+	// Name returns the tool's name, as given to the -synth flag.
+	Name() string
+
+	// ScriptFor returns the contents of the script or project file the
+	// tool needs in order to perform the conversion.
+	ScriptFor(p *Parameters) string
+
+	// Run invokes the tool, producing p.OutFileBase+".edif" from
+	// p.OutFileBase+".v".
+	Run(p *Parameters) error
+
+	// RunNonFatal behaves like Run, but reports a failing run as a
+	// returned *PipelineError instead of aborting the process. The
+	// fuzzer and reducer need "the backend rejected this candidate" to be
+	// a routine, recoverable outcome rather than a fatal one.
+	RunNonFatal(p *Parameters) error
+}
+
+// synthTools maps each -synth flag value to its SynthTool implementation.
+var synthTools = map[string]SynthTool{
+	"yosys":   YosysSynth{},
+	"vivado":  VivadoSynth{},
+	"quartus": QuartusSynth{},
+}
+
+// SynthToolByName looks up a SynthTool by the name given to -synth.
+func SynthToolByName(name string) (SynthTool, error) {
+	t, ok := synthTools[name]
+	if !ok {
+		return nil, fmt.Errorf("unrecognized synthesis backend %q", name)
+	}
+	return t, nil
+}
+
+// YosysSynth lowers Verilog to EDIF using Yosys, the original and default
+// backend.
+type YosysSynth struct{}
+
+// Name returns "yosys".
+func (YosysSynth) Name() string { return "yosys" }
+
+// ScriptFor returns the contents of the Yosys script written by
+// CreateYosysScript.
+func (YosysSynth) ScriptFor(p *Parameters) string {
+	CreateYosysScript(p)
+	script, err := ioutil.ReadFile(p.OutFileBase + ".ys")
+	CheckError(err)
+	return string(script)
+}
+
+// Run writes the Yosys script and invokes yosys to produce an EDIF netlist.
+func (YosysSynth) Run(p *Parameters) error {
+	CreateYosysScript(p)
+	RunCommand(p, "yosys", "-q", "-s", p.OutFileBase+".ys",
+		"-b", "edif", "-o", p.OutFileBase+".edif", p.OutFileBase+".v")
+	return nil
+}
+
+// RunNonFatal writes the Yosys script and invokes yosys, reporting a
+// non-zero exit as a *PipelineError instead of aborting the process.
+func (YosysSynth) RunNonFatal(p *Parameters) error {
+	CreateYosysScript(p)
+	return runPipelineStep("synthesis", "yosys", "-q", "-s", p.OutFileBase+".ys",
+		"-b", "edif", "-o", p.OutFileBase+".edif", p.OutFileBase+".v")
+}
+
+// VivadoSynth lowers Verilog to EDIF using Xilinx Vivado.
+type VivadoSynth struct{}
+
+// Name returns "vivado".
+func (VivadoSynth) Name() string { return "vivado" }
+
+// ScriptFor returns a batch-mode Tcl script that reads the generated
+// Verilog, runs out-of-context synthesis, and writes an EDIF netlist.
+func (VivadoSynth) ScriptFor(p *Parameters) string {
+	return fmt.Sprintf(`read_verilog %[1]s.v
+synth_design -top %[1]s -mode out_of_context
+write_edif -force %[1]s.edif
+`, p.OutFileBase)
+}
+
+// Run writes the Vivado Tcl script and invokes Vivado in batch mode to
+// produce an EDIF netlist.
+func (VivadoSynth) Run(p *Parameters) error {
+	tclName := p.OutFileBase + "_vivado.tcl"
+	err := ioutil.WriteFile(tclName, []byte(VivadoSynth{}.ScriptFor(p)), 0644)
+	if err != nil {
+		return err
+	}
+	RunCommand(p, "vivado", "-mode", "batch", "-nojournal", "-nolog", "-source", tclName)
+	return nil
+}
+
+// RunNonFatal writes the Vivado Tcl script and invokes Vivado, reporting
+// a non-zero exit as a *PipelineError instead of aborting the process.
+func (VivadoSynth) RunNonFatal(p *Parameters) error {
+	tclName := p.OutFileBase + "_vivado.tcl"
+	if err := ioutil.WriteFile(tclName, []byte(VivadoSynth{}.ScriptFor(p)), 0644); err != nil {
+		return err
+	}
+	return runPipelineStep("synthesis", "vivado", "-mode", "batch", "-nojournal", "-nolog", "-source", tclName)
+}
+
+// QuartusSynth lowers Verilog to EDIF using Intel/Altera Quartus.
+type QuartusSynth struct{}
+
+// Name returns "quartus".
+func (QuartusSynth) Name() string { return "quartus" }
+
+// ScriptFor returns a quartus_sh Tcl script that creates a throwaway
+// project for the generated Verilog, runs analysis and synthesis, and
+// exports the result as EDIF.
+func (QuartusSynth) ScriptFor(p *Parameters) string {
+	return fmt.Sprintf(`load_package flow
+project_new %[1]s -overwrite
+set_global_assignment -name TOP_LEVEL_ENTITY %[1]s
+set_global_assignment -name VERILOG_FILE %[1]s.v
+execute_module -tool map
+execute_module -tool eda_netlist_writer -args "--formal_verification --tool=edif"
+project_close
+`, p.OutFileBase)
+}
+
+// Run writes the Quartus Tcl script and invokes quartus_sh to produce an
+// EDIF netlist.
+func (QuartusSynth) Run(p *Parameters) error {
+	tclName := p.OutFileBase + "_quartus.tcl"
+	err := ioutil.WriteFile(tclName, []byte(QuartusSynth{}.ScriptFor(p)), 0644)
+	if err != nil {
+		return err
+	}
+	RunCommand(p, "quartus_sh", "-t", tclName)
+	return nil
+}
+
+// RunNonFatal writes the Quartus Tcl script and invokes quartus_sh,
+// reporting a non-zero exit as a *PipelineError instead of aborting the
+// process.
+func (QuartusSynth) RunNonFatal(p *Parameters) error {
+	tclName := p.OutFileBase + "_quartus.tcl"
+	if err := ioutil.WriteFile(tclName, []byte(QuartusSynth{}.ScriptFor(p)), 0644); err != nil {
+		return err
+	}
+	return runPipelineStep("synthesis", "quartus_sh", "-t", tclName)
+}